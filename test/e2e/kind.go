@@ -0,0 +1,168 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	kindv1alpha4 "sigs.k8s.io/kind/pkg/apis/config/v1alpha4"
+	"sigs.k8s.io/kind/pkg/cluster"
+
+	"github.com/GoogleCloudPlatform/secrets-store-csi-driver-provider-gcp/test/internal/fakesecretmanager"
+)
+
+// kindDockerNetwork is the Docker network kind attaches its nodes to. Pods
+// inside those nodes can reach services on the host through this network's
+// gateway address, but not through "localhost" or "127.0.0.1" — each kind
+// node is its own Docker container with its own loopback interface.
+const kindDockerNetwork = "kind"
+
+// credentialsMountPath is where the throwaway GOOGLE_APPLICATION_CREDENTIALS
+// file is mounted inside every kind node (via extraMounts) and, from there,
+// into the provider container (via a matching hostPath volume), so the
+// client libraries find credentials without a real GCP service account.
+const credentialsMountPath = "/etc/gcp-sa/credentials.json"
+
+// kindProvider manages the local kind cluster used by the E2E_MODE=kind
+// driver. It is nil outside of that mode.
+var kindProvider *cluster.Provider
+
+// Executed before any tests are run when E2E_MODE=kind. Stands up a local
+// kind cluster and an in-process fake Secret Manager instead of a real GKE
+// cluster and project, so the suite runs without GCP credentials. It still
+// downloads the Secrets Store CSI Driver manifests from GitHub.
+func setupTestSuiteKind() {
+	f.testClusterName = fmt.Sprintf("kind-%d", os.Getpid())
+	f.testSecretID = fmt.Sprintf("testsecret-%d", os.Getpid())
+	f.testProjectID = "fake-project"
+	setRotationAndSyncDefaults(fmt.Sprintf("%d", os.Getpid()))
+	f.secretStoreVersion = os.Getenv("SECRET_STORE_VERSION")
+	if len(f.secretStoreVersion) == 0 {
+		f.secretStoreVersion = "master"
+	}
+
+	tempDir, err := ioutil.TempDir("", "csi-tests-kind")
+	check(err)
+	f.tempDir = tempDir
+
+	// Write the throwaway credentials file before the cluster comes up so it
+	// can be bind-mounted into every node at creation time.
+	credFile := filepath.Join(f.tempDir, "fake-credentials.json")
+	check(ioutil.WriteFile(credFile, []byte(fakeApplicationCredentials), 0644))
+
+	// Bring up a local kind cluster first: it creates the "kind" Docker
+	// network our fake Secret Manager needs to be reachable from, and
+	// mounting the credentials file in now makes it available to every
+	// node's filesystem for the DaemonSet's hostPath volume to pick up.
+	kindProvider = cluster.NewProvider()
+	check(kindProvider.Create(f.testClusterName, cluster.CreateWithV1Alpha4Config(&kindv1alpha4.Cluster{
+		Nodes: []kindv1alpha4.Node{
+			{
+				Role: kindv1alpha4.ControlPlaneRole,
+				ExtraMounts: []kindv1alpha4.Mount{
+					{HostPath: credFile, ContainerPath: credentialsMountPath, Readonly: true},
+				},
+			},
+		},
+	})))
+
+	f.kubeconfigFile = filepath.Join(f.tempDir, "test-cluster-kubeconfig")
+	check(kindProvider.ExportKubeConfig(f.testClusterName, f.kubeconfigFile, false))
+
+	// Launch the fake Secret Manager and seed it with the test secret. It
+	// binds all interfaces, and we address it via the kind network's
+	// gateway IP so pods running inside kind nodes can dial out to it.
+	fakeSM, err := fakesecretmanager.New()
+	check(err)
+	fakeSM.PutSecret(f.testProjectID, f.testSecretID, []byte(f.testSecretID))
+	gatewayIP, err := kindNetworkGatewayIP()
+	check(err)
+	f.smEndpoint = fmt.Sprintf("%s:%d", gatewayIP, fakeSM.Port())
+	f.fakeSecretManager = fakeSM
+	f.googleApplicationCredentials = credentialsMountPath
+
+	// Build the plugin deploy yaml, pointed at the fake Secret Manager. Its
+	// DaemonSet template is expected to mount credentialsMountPath as a
+	// hostPath volume and set GOOGLE_APPLICATION_CREDENTIALS to
+	// $GOOGLE_APPLICATION_CREDENTIALS so the provider's client libraries
+	// find the throwaway credentials written above.
+	pluginFile := filepath.Join(f.tempDir, "provider-gcp-plugin.yaml")
+	check(replaceTemplate("templates/provider-gcp-plugin.yaml.tmpl", pluginFile))
+	check(execCmd(exec.Command("kubectl", "apply", "--kubeconfig", f.kubeconfigFile, "-f", pluginFile)))
+
+	// Install Secret Store
+	check(execCmd(exec.Command("kubectl", "apply", "--kubeconfig", f.kubeconfigFile,
+		"-f", fmt.Sprintf("https://raw.githubusercontent.com/kubernetes-sigs/secrets-store-csi-driver/%s/deploy/rbac-secretproviderclass.yaml", f.secretStoreVersion),
+		"-f", fmt.Sprintf("https://raw.githubusercontent.com/kubernetes-sigs/secrets-store-csi-driver/%s/deploy/rbac-secretprovidersyncing.yaml", f.secretStoreVersion),
+		"-f", fmt.Sprintf("https://raw.githubusercontent.com/kubernetes-sigs/secrets-store-csi-driver/%s/deploy/csidriver.yaml", f.secretStoreVersion),
+		"-f", fmt.Sprintf("https://raw.githubusercontent.com/kubernetes-sigs/secrets-store-csi-driver/%s/deploy/secrets-store.csi.x-k8s.io_secretproviderclasses.yaml", f.secretStoreVersion),
+		"-f", fmt.Sprintf("https://raw.githubusercontent.com/kubernetes-sigs/secrets-store-csi-driver/%s/deploy/secrets-store.csi.x-k8s.io_secretproviderclasspodstatuses.yaml", f.secretStoreVersion),
+		"-f", fmt.Sprintf("https://raw.githubusercontent.com/kubernetes-sigs/secrets-store-csi-driver/%s/deploy/secrets-store-csi-driver.yaml", f.secretStoreVersion),
+	)))
+	check(enableSecretRotation())
+}
+
+// kindNetworkGatewayIP returns the gateway address of the "kind" Docker
+// network, i.e. the address of the host as seen from inside a kind node.
+func kindNetworkGatewayIP() (string, error) {
+	var stdout bytes.Buffer
+	command := exec.Command("docker", "network", "inspect", kindDockerNetwork,
+		"-f", "{{(index .IPAM.Config 0).Gateway}}")
+	command.Stdout = &stdout
+	if err := command.Run(); err != nil {
+		return "", fmt.Errorf("inspecting %q docker network: %w", kindDockerNetwork, err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// Executed after tests are run when E2E_MODE=kind.
+func teardownTestSuiteKind() {
+	os.RemoveAll(f.tempDir)
+	if kindProvider != nil {
+		kindProvider.Delete(f.testClusterName, f.kubeconfigFile)
+	}
+}
+
+// runTestKind is the E2E_MODE=kind counterpart to runTest: it drives the
+// same test suite against a local kind cluster and a fake Secret Manager
+// instead of a real GKE cluster and project.
+func runTestKind(m *testing.M) (code int) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("Test execution panic:", r)
+			code = 1
+		}
+		teardownTestSuiteKind()
+	}()
+
+	setupTestSuiteKind()
+	return m.Run()
+}
+
+// fakeApplicationCredentials is a throwaway GOOGLE_APPLICATION_CREDENTIALS
+// payload accepted by the client libraries; its contents are never checked
+// by the fake Secret Manager server.
+const fakeApplicationCredentials = `{
+  "type": "authorized_user",
+  "client_id": "fake",
+  "client_secret": "fake",
+  "refresh_token": "fake"
+}`
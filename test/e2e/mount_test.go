@@ -25,6 +25,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/GoogleCloudPlatform/secrets-store-csi-driver-provider-gcp/test/internal/fakesecretmanager"
 )
 
 // zone to set up test cluster in
@@ -38,6 +40,30 @@ type testFixture struct {
 	kubeconfigFile     string
 	testProjectID      string
 	secretStoreVersion string
+
+	// Only populated when E2E_MODE=kind. smEndpoint is the address of the
+	// in-process fake Secret Manager server that the plugin DaemonSet is
+	// configured to dial instead of secretmanager.googleapis.com.
+	smEndpoint string
+
+	// rotationPollInterval is how often the driver's rotation reconciler
+	// checks for new secret versions, used by TestRotationReconciler.
+	rotationPollInterval string
+
+	// syncSecretName is the name of the Kubernetes Secret TestSyncAsKubernetesSecret
+	// expects the driver to create via a SecretProviderClass's secretObjects.
+	syncSecretName string
+
+	// fakeSecretManager is only non-nil under E2E_MODE=kind. TestRotationReconciler
+	// uses it to add a new secret version directly instead of shelling out
+	// to `gcloud secrets versions add`, since there is no real GCP project
+	// to call out to in that mode.
+	fakeSecretManager *fakesecretmanager.Server
+
+	// googleApplicationCredentials is only populated under E2E_MODE=kind: the
+	// in-container path of the throwaway credentials file the plugin
+	// DaemonSet should mount and point GOOGLE_APPLICATION_CREDENTIALS at.
+	googleApplicationCredentials string
 }
 
 var f testFixture
@@ -74,9 +100,36 @@ func replaceTemplate(templateFile string, destFile string) error {
 	template = strings.ReplaceAll(template, "$TEST_SECRET_ID", f.testSecretID)
 	template = strings.ReplaceAll(template, "$GCP_PROVIDER_SHA", f.gcpProviderBranch)
 	template = strings.ReplaceAll(template, "$ZONE", zone)
+	template = strings.ReplaceAll(template, "$SECRETMANAGER_ENDPOINT", f.smEndpoint)
+	template = strings.ReplaceAll(template, "$GOOGLE_APPLICATION_CREDENTIALS", f.googleApplicationCredentials)
+	template = strings.ReplaceAll(template, "$ROTATION_POLL_INTERVAL", f.rotationPollInterval)
+	template = strings.ReplaceAll(template, "$SYNC_SECRET_NAME", f.syncSecretName)
 	return ioutil.WriteFile(destFile, []byte(template), 0644)
 }
 
+// setRotationAndSyncDefaults fills in the fixture fields TestRotationReconciler
+// and TestSyncAsKubernetesSecret need, using suffix to keep the synced
+// Secret's name unique across runs. Shared by setupTestSuite and
+// setupTestSuiteKind so neither path forgets to set them.
+func setRotationAndSyncDefaults(suffix string) {
+	f.syncSecretName = fmt.Sprintf("testsyncsecret-%s", suffix)
+	f.rotationPollInterval = "2s"
+}
+
+// enableSecretRotation patches the just-installed driver DaemonSet to turn
+// on its rotation reconciler, which ships disabled by default upstream, and
+// to use the fixture's poll interval. Shared by setupTestSuite and
+// setupTestSuiteKind.
+func enableSecretRotation() error {
+	patch := fmt.Sprintf(
+		`[{"op":"add","path":"/spec/template/spec/containers/0/args/-","value":"--enable-secret-rotation=true"},`+
+			`{"op":"add","path":"/spec/template/spec/containers/0/args/-","value":"--rotation-poll-interval=%s"}]`,
+		f.rotationPollInterval)
+	return execCmd(exec.Command("kubectl", "patch", "daemonset", "csi-secrets-store",
+		"--kubeconfig", f.kubeconfigFile, "--namespace", "kube-system",
+		"--type", "json", "-p", patch))
+}
+
 // Executed before any tests are run. Setup is only run once for all tests in the suite.
 func setupTestSuite() {
 	rand.Seed(time.Now().UTC().UnixNano())
@@ -100,6 +153,7 @@ func setupTestSuite() {
 	f.tempDir = tempDir
 	f.testClusterName = fmt.Sprintf("testcluster-%d", rand.Int31())
 	f.testSecretID = fmt.Sprintf("testsecret-%d", rand.Int31())
+	setRotationAndSyncDefaults(fmt.Sprintf("%d", rand.Int31()))
 
 	// Build the plugin deploy yaml
 	pluginFile := filepath.Join(tempDir, "provider-gcp-plugin.yaml")
@@ -128,6 +182,7 @@ func setupTestSuite() {
 		"-f", fmt.Sprintf("https://raw.githubusercontent.com/kubernetes-sigs/secrets-store-csi-driver/%s/deploy/secrets-store.csi.x-k8s.io_secretproviderclasspodstatuses.yaml", f.secretStoreVersion),
 		"-f", fmt.Sprintf("https://raw.githubusercontent.com/kubernetes-sigs/secrets-store-csi-driver/%s/deploy/secrets-store-csi-driver.yaml", f.secretStoreVersion),
 	)))
+	check(enableSecretRotation())
 
 	// Install GCP Plugin and Workload Identity bindings
 	check(execCmd(exec.Command("kubectl", "apply", "--kubeconfig", f.kubeconfigFile,
@@ -149,6 +204,9 @@ func teardownTestSuite() {
 
 // Entry point for go test.
 func TestMain(m *testing.M) {
+	if os.Getenv("E2E_MODE") == "kind" {
+		os.Exit(runTestKind(m))
+	}
 	os.Exit(runTest(m))
 }
 
@@ -0,0 +1,196 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// secretProviderClassPodStatus is the subset of
+// secrets-store-csi-driver's SecretProviderClassPodStatus this package
+// needs to observe rotation progress.
+type secretProviderClassPodStatus struct {
+	Status struct {
+		Objects []struct {
+			ID      string `json:"id"`
+			Version string `json:"version"`
+		} `json:"objects"`
+	} `json:"status"`
+}
+
+// objectVersion returns the objectVersion the driver last recorded for id in
+// the SecretProviderClassPodStatus for podName, or "" if it hasn't reported
+// one yet.
+func objectVersion(podName, id string) (string, error) {
+	var stdout bytes.Buffer
+	command := exec.Command("kubectl", "get", "secretproviderclasspodstatuses",
+		fmt.Sprintf("%s-default-test-secret-provider", podName),
+		"--kubeconfig", f.kubeconfigFile, "--namespace", "default", "-o", "json")
+	command.Stdout = &stdout
+	if err := command.Run(); err != nil {
+		return "", err
+	}
+
+	var status secretProviderClassPodStatus
+	if err := json.Unmarshal(stdout.Bytes(), &status); err != nil {
+		return "", err
+	}
+	for _, obj := range status.Status.Objects {
+		if obj.ID == id {
+			return obj.Version, nil
+		}
+	}
+	return "", nil
+}
+
+// waitForObjectVersion polls objectVersion until it differs from prevVersion
+// or timeout elapses.
+func waitForObjectVersion(podName, id, prevVersion string, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		version, err := objectVersion(podName, id)
+		if err != nil {
+			return "", err
+		}
+		if version != "" && version != prevVersion {
+			return version, nil
+		}
+		time.Sleep(5 * time.Second)
+	}
+	return "", fmt.Errorf("objectVersion for %q did not change from %q within %s", id, prevVersion, timeout)
+}
+
+// waitForInitialObjectVersion polls objectVersion until the driver has
+// reported one. The SecretProviderClassPodStatus is written by the driver's
+// controller asynchronously from Pod readiness, so a Ready Pod does not
+// guarantee objectVersion is populated yet; starting from an empty
+// prevVersion would make waitForObjectVersion return on the pre-rotation
+// version instead of the rotated one.
+func waitForInitialObjectVersion(podName, id string, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		version, err := objectVersion(podName, id)
+		if err != nil {
+			return "", err
+		}
+		if version != "" {
+			return version, nil
+		}
+		time.Sleep(5 * time.Second)
+	}
+	return "", fmt.Errorf("objectVersion for %q was not reported within %s", id, timeout)
+}
+
+// TestRotationReconciler enables the driver's rotation reconciler, adds a
+// new version of the test secret, and asserts the mounted file picks up the
+// new value. It waits up to two minutes for the new objectVersion, not just
+// one rotation poll interval, and does not check whether the Pod restarted.
+func TestRotationReconciler(t *testing.T) {
+	podFile := filepath.Join(f.tempDir, "test-pod-rotation.yaml")
+	if err := replaceTemplate("templates/test-pod.yaml.tmpl", podFile); err != nil {
+		t.Fatalf("Error replacing pod template: %v", err)
+	}
+	if err := execCmd(exec.Command("kubectl", "apply", "--kubeconfig", f.kubeconfigFile,
+		"--namespace", "default", "-f", podFile)); err != nil {
+		t.Fatalf("Error creating pod: %v", err)
+	}
+
+	time.Sleep(5 * time.Second)
+	if err := execCmd(exec.Command("kubectl", "wait", "pod/test-secret-mounter", "--for=condition=Ready",
+		"--kubeconfig", f.kubeconfigFile, "--namespace", "default", "--timeout", "5m")); err != nil {
+		t.Fatalf("Error waiting for pod: %v", err)
+	}
+
+	prevVersion, err := waitForInitialObjectVersion("test-secret-mounter", f.testSecretID, 2*time.Minute)
+	if err != nil {
+		t.Fatalf("Error reading initial objectVersion: %v", err)
+	}
+
+	rotatedValue := fmt.Sprintf("%s-rotated", f.testSecretID)
+	if f.fakeSecretManager != nil {
+		// E2E_MODE=kind: there's no real GCP project to call `gcloud` against,
+		// so add the new version directly to the fake backend instead.
+		f.fakeSecretManager.PutSecret(f.testProjectID, f.testSecretID, []byte(rotatedValue))
+	} else {
+		rotatedFile := filepath.Join(f.tempDir, "rotatedSecretValue")
+		if err := ioutil.WriteFile(rotatedFile, []byte(rotatedValue), 0644); err != nil {
+			t.Fatalf("Error writing rotated secret value: %v", err)
+		}
+		if err := execCmd(exec.Command("gcloud", "secrets", "versions", "add", f.testSecretID,
+			"--data-file", rotatedFile, "--project", f.testProjectID)); err != nil {
+			t.Fatalf("Error adding rotated secret version: %v", err)
+		}
+	}
+
+	if _, err := waitForObjectVersion("test-secret-mounter", f.testSecretID, prevVersion, 2*time.Minute); err != nil {
+		t.Fatalf("Error waiting for rotation to be reconciled: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	command := exec.Command("kubectl", "exec", "test-secret-mounter",
+		"--kubeconfig", f.kubeconfigFile, "--namespace", "default",
+		"--", "cat", fmt.Sprintf("/var/gcp-test-secrets/%s", f.testSecretID))
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		fmt.Println("Stdout:", stdout.String())
+		fmt.Println("Stderr:", stderr.String())
+		t.Fatalf("Could not read rotated secret from container: %v", err)
+	}
+	if stdout.String() != rotatedValue {
+		t.Fatalf("Secret value after rotation is %v, want: %v", stdout.String(), rotatedValue)
+	}
+}
+
+// TestSyncAsKubernetesSecret asserts that a SecretProviderClass with a
+// secretObjects stanza causes the driver to create a matching
+// corev1.Secret, and that deleting the mounting Pod garbage-collects it.
+func TestSyncAsKubernetesSecret(t *testing.T) {
+	podFile := filepath.Join(f.tempDir, "test-pod-sync.yaml")
+	if err := replaceTemplate("templates/test-pod.yaml.tmpl", podFile); err != nil {
+		t.Fatalf("Error replacing pod template: %v", err)
+	}
+	if err := execCmd(exec.Command("kubectl", "apply", "--kubeconfig", f.kubeconfigFile,
+		"--namespace", "default", "-f", podFile)); err != nil {
+		t.Fatalf("Error creating pod: %v", err)
+	}
+
+	time.Sleep(5 * time.Second)
+	if err := execCmd(exec.Command("kubectl", "wait", "pod/test-secret-mounter", "--for=condition=Ready",
+		"--kubeconfig", f.kubeconfigFile, "--namespace", "default", "--timeout", "5m")); err != nil {
+		t.Fatalf("Error waiting for pod: %v", err)
+	}
+
+	if err := execCmd(exec.Command("kubectl", "get", "secret", f.syncSecretName,
+		"--kubeconfig", f.kubeconfigFile, "--namespace", "default")); err != nil {
+		t.Fatalf("Synced Secret %q was not created: %v", f.syncSecretName, err)
+	}
+
+	if err := execCmd(exec.Command("kubectl", "delete", "pod", "test-secret-mounter",
+		"--kubeconfig", f.kubeconfigFile, "--namespace", "default", "--wait=true", "--timeout", "2m")); err != nil {
+		t.Fatalf("Error deleting pod: %v", err)
+	}
+
+	if err := execCmd(exec.Command("kubectl", "wait", "secret/"+f.syncSecretName, "--for=delete",
+		"--kubeconfig", f.kubeconfigFile, "--namespace", "default", "--timeout", "2m")); err != nil {
+		t.Fatalf("Synced Secret %q was not garbage-collected after Pod deletion: %v", f.syncSecretName, err)
+	}
+}
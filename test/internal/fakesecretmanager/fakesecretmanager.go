@@ -0,0 +1,183 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fakesecretmanager is an in-process, hermetic implementation of
+// secretmanager.v1.SecretManagerService shared by the test/e2e and
+// test/sanity suites, so neither needs a real GCP project to exercise the
+// provider against.
+package fakesecretmanager
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	secretmanagerpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server is a fake secretmanager.v1.SecretManagerService.
+type Server struct {
+	secretmanagerpb.UnimplementedSecretManagerServiceServer
+
+	// port is the TCP port the server is listening on. It binds 0.0.0.0
+	// rather than localhost: callers from outside this host's network
+	// namespace (e.g. a pod running inside a kind node, which is itself a
+	// separate Docker container) cannot reach "localhost"/"127.0.0.1" here.
+	port int
+
+	mu       sync.Mutex
+	secrets  map[string][]byte // keyed by "projects/<project>/secrets/<secret>"
+	versions map[string]int    // latest version number, same keys as secrets
+	denied   map[string]bool
+	delay    map[string]time.Duration
+}
+
+// New starts a fake Secret Manager server on a free port on all interfaces.
+// The server runs for the lifetime of the test process; callers address it
+// with their own choice of host and Port().
+func New() (*Server, error) {
+	lis, err := net.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		port:     lis.Addr().(*net.TCPAddr).Port,
+		secrets:  map[string][]byte{},
+		versions: map[string]int{},
+		denied:   map[string]bool{},
+		delay:    map[string]time.Duration{},
+	}
+
+	grpcServer := grpc.NewServer()
+	secretmanagerpb.RegisterSecretManagerServiceServer(grpcServer, s)
+	go grpcServer.Serve(lis)
+
+	return s, nil
+}
+
+// Port returns the TCP port the server is listening on.
+func (s *Server) Port() int {
+	return s.port
+}
+
+// PutSecret seeds the fake with a secret's latest version payload, as if it
+// had just been created or rotated via `gcloud secrets versions add`: each
+// call bumps the secret's version number.
+func (s *Server) PutSecret(project, secret string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secrets[secretName(project, secret)] = value
+	s.versions[secretName(project, secret)]++
+}
+
+// DenyAccess makes AccessSecretVersion return PermissionDenied for secret
+// until AllowAccess is called.
+func (s *Server) DenyAccess(project, secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.denied[secretName(project, secret)] = true
+}
+
+// AllowAccess undoes a prior DenyAccess.
+func (s *Server) AllowAccess(project, secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.denied, secretName(project, secret))
+}
+
+// Delay makes AccessSecretVersion block for d before responding, to exercise
+// context cancellation. d == 0 clears any configured delay.
+func (s *Server) Delay(project, secret string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d == 0 {
+		delete(s.delay, secretName(project, secret))
+		return
+	}
+	s.delay[secretName(project, secret)] = d
+}
+
+func secretName(project, secret string) string {
+	return fmt.Sprintf("projects/%s/secrets/%s", project, secret)
+}
+
+// GetSecret reports whether a secret exists, mirroring the real API's
+// behavior of returning NotFound for unknown secrets.
+func (s *Server) GetSecret(ctx context.Context, req *secretmanagerpb.GetSecretRequest) (*secretmanagerpb.Secret, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.secrets[req.GetName()]; !ok {
+		return nil, status.Errorf(codes.NotFound, "secret %q not found", req.GetName())
+	}
+	return &secretmanagerpb.Secret{Name: req.GetName()}, nil
+}
+
+// AccessSecretVersion serves the seeded payload for "latest", named after its
+// current version number, honoring any configured DenyAccess/Delay, and
+// NotFound otherwise.
+func (s *Server) AccessSecretVersion(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+	secret, version, ok := splitVersionName(req.GetName())
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "malformed secret version name %q", req.GetName())
+	}
+	if version != "latest" {
+		return nil, status.Errorf(codes.NotFound, "version %q not found", version)
+	}
+
+	s.mu.Lock()
+	delay := s.delay[secret]
+	denied := s.denied[secret]
+	payload, ok := s.secrets[secret]
+	current := s.versions[secret]
+	s.mu.Unlock()
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		case <-timer.C:
+		}
+	}
+	if denied {
+		return nil, status.Errorf(codes.PermissionDenied, "permission denied on %q", secret)
+	}
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "secret %q not found", secret)
+	}
+
+	return &secretmanagerpb.AccessSecretVersionResponse{
+		Name:    fmt.Sprintf("%s/versions/%d", secret, current),
+		Payload: &secretmanagerpb.SecretPayload{Data: payload},
+	}, nil
+}
+
+// splitVersionName splits "projects/p/secrets/s/versions/v" into
+// ("projects/p/secrets/s", "v").
+func splitVersionName(name string) (secret, version string, ok bool) {
+	const sep = "/versions/"
+	i := strings.Index(name, sep)
+	if i < 0 {
+		return "", "", false
+	}
+	return name[:i], name[i+len(sep):], true
+}
@@ -0,0 +1,246 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sanity drives a built provider binary over its CSIDriverProvider
+// gRPC socket and asserts it honors the wire contract the Secrets Store CSI
+// Driver depends on, independent of any real Kubernetes cluster or GCP
+// project. It is modeled on kubernetes-csi/csi-test's sanity package: point
+// it at a socket and a fake backend, and it drives a table of protocol-level
+// scenarios against whatever is listening.
+package sanity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	v1alpha1 "github.com/kubernetes-sigs/secrets-store-csi-driver/provider/v1alpha1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/GoogleCloudPlatform/secrets-store-csi-driver-provider-gcp/test/internal/fakesecretmanager"
+)
+
+// Config describes the provider under test.
+type Config struct {
+	// SocketPath is the unix socket the provider binary is already
+	// listening on (e.g. started by the caller with a -endpoint flag
+	// pointed here).
+	SocketPath string
+
+	// FakeSecretManager, when set, is used to seed and inspect the backend
+	// the provider dials instead of a real secretmanager.googleapis.com.
+	FakeSecretManager *fakesecretmanager.Server
+
+	// TargetPath is the directory the provider is asked to mount secrets
+	// into. Test removes and recreates it before running scenarios, and
+	// removes it again once they finish, so repeated runs against the same
+	// path never see files left behind by a previous run.
+	TargetPath string
+}
+
+// Test runs the full sanity suite as subtests of t against the provider
+// described by cfg.
+func Test(t *testing.T, cfg Config) {
+	if err := os.RemoveAll(cfg.TargetPath); err != nil {
+		t.Fatalf("clearing TargetPath %s: %v", cfg.TargetPath, err)
+	}
+	if err := os.MkdirAll(cfg.TargetPath, 0755); err != nil {
+		t.Fatalf("creating TargetPath %s: %v", cfg.TargetPath, err)
+	}
+	defer os.RemoveAll(cfg.TargetPath)
+
+	conn, err := grpc.Dial(cfg.SocketPath,
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", addr)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("dialing provider at %s: %v", cfg.SocketPath, err)
+	}
+	defer conn.Close()
+	client := v1alpha1.NewCSIDriverProviderClient(conn)
+
+	t.Run("Version", func(t *testing.T) { testVersion(t, client) })
+	t.Run("SingleSecret", func(t *testing.T) { testSingleSecret(t, client, cfg) })
+	t.Run("MultipleSecrets", func(t *testing.T) { testMultipleSecrets(t, client, cfg) })
+	t.Run("PermissionDenied", func(t *testing.T) { testPermissionDenied(t, client, cfg) })
+	t.Run("MissingSecretVersion", func(t *testing.T) { testMissingSecretVersion(t, client, cfg) })
+	t.Run("MalformedParameters", func(t *testing.T) { testMalformedParameters(t, client, cfg) })
+	t.Run("OversizedPayload", func(t *testing.T) { testOversizedPayload(t, client, cfg) })
+	t.Run("CancelledContext", func(t *testing.T) { testCancelledContext(t, client, cfg) })
+}
+
+// testVersion asserts Version reports the runtime name contributors expect
+// to see in `kubectl logs`/metrics.
+func testVersion(t *testing.T, client v1alpha1.CSIDriverProviderClient) {
+	resp, err := client.Version(context.Background(), &v1alpha1.VersionRequest{})
+	if err != nil {
+		t.Fatalf("Version() returned error: %v", err)
+	}
+	if resp.GetRuntimeName() != "secrets-store-csi-driver-provider-gcp" {
+		t.Errorf("Version().RuntimeName = %q, want %q", resp.GetRuntimeName(), "secrets-store-csi-driver-provider-gcp")
+	}
+}
+
+// testSingleSecret drives a valid MountRequest for one secret and checks
+// the response shape: one File per requested secret, correct contents, a
+// non-zero file mode, and an ObjectVersion entry per secret.
+func testSingleSecret(t *testing.T, client v1alpha1.CSIDriverProviderClient, cfg Config) {
+	secretID := "sanity-single-secret"
+	value := []byte("sanity-test-value")
+	cfg.FakeSecretManager.PutSecret("sanity-project", secretID, value)
+
+	req := mountRequest(t, cfg, "sanity-project", []string{secretID})
+	resp, err := client.Mount(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Mount() returned error: %v", err)
+	}
+	if len(resp.GetFiles()) != 1 {
+		t.Fatalf("Mount() returned %d files, want 1", len(resp.GetFiles()))
+	}
+	got := resp.GetFiles()[0]
+	if got.GetPath() != secretID {
+		t.Errorf("file path = %q, want %q", got.GetPath(), secretID)
+	}
+	if string(got.GetContents()) != string(value) {
+		t.Errorf("file contents = %q, want %q", got.GetContents(), value)
+	}
+	if got.GetMode() == 0 {
+		t.Errorf("file mode is 0, want a valid permission bitmask")
+	}
+	if len(resp.GetObjectVersion()) != 1 {
+		t.Errorf("Mount() returned %d object versions, want 1", len(resp.GetObjectVersion()))
+	}
+}
+
+// testMultipleSecrets exercises the same path as testSingleSecret but with
+// several secrets in one request, the common case for a SecretProviderClass
+// with multiple objects.
+func testMultipleSecrets(t *testing.T, client v1alpha1.CSIDriverProviderClient, cfg Config) {
+	ids := []string{"sanity-multi-a", "sanity-multi-b", "sanity-multi-c"}
+	for _, id := range ids {
+		cfg.FakeSecretManager.PutSecret("sanity-project", id, []byte(id))
+	}
+
+	req := mountRequest(t, cfg, "sanity-project", ids)
+	resp, err := client.Mount(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Mount() returned error: %v", err)
+	}
+	if len(resp.GetFiles()) != len(ids) {
+		t.Fatalf("Mount() returned %d files, want %d", len(resp.GetFiles()), len(ids))
+	}
+}
+
+// testPermissionDenied asserts a secret the fake backend rejects access to
+// surfaces as a gRPC error rather than an empty/partial mount.
+func testPermissionDenied(t *testing.T, client v1alpha1.CSIDriverProviderClient, cfg Config) {
+	secretID := "sanity-denied-secret"
+	cfg.FakeSecretManager.PutSecret("sanity-project", secretID, []byte("unreachable"))
+	cfg.FakeSecretManager.DenyAccess("sanity-project", secretID)
+	defer cfg.FakeSecretManager.AllowAccess("sanity-project", secretID)
+
+	req := mountRequest(t, cfg, "sanity-project", []string{secretID})
+	if _, err := client.Mount(context.Background(), req); err == nil {
+		t.Fatalf("Mount() succeeded for a permission-denied secret, want error")
+	}
+}
+
+// testMissingSecretVersion asserts requesting a secret that was never
+// created surfaces NotFound rather than an empty file.
+func testMissingSecretVersion(t *testing.T, client v1alpha1.CSIDriverProviderClient, cfg Config) {
+	req := mountRequest(t, cfg, "sanity-project", []string{"sanity-never-created"})
+	_, err := client.Mount(context.Background(), req)
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("Mount() returned code %v, want NotFound", status.Code(err))
+	}
+}
+
+// testMalformedParameters asserts a MountRequest whose attributes field
+// isn't valid YAML/JSON is rejected with InvalidArgument instead of
+// panicking the provider.
+func testMalformedParameters(t *testing.T, client v1alpha1.CSIDriverProviderClient, cfg Config) {
+	req := &v1alpha1.MountRequest{
+		Attributes: "{not valid: [yaml",
+		TargetPath: cfg.TargetPath,
+		Permission: "420",
+	}
+	_, err := client.Mount(context.Background(), req)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Mount() returned code %v, want InvalidArgument", status.Code(err))
+	}
+}
+
+// testOversizedPayload asserts a secret payload larger than the driver's
+// file size budget is rejected rather than silently truncated.
+func testOversizedPayload(t *testing.T, client v1alpha1.CSIDriverProviderClient, cfg Config) {
+	secretID := "sanity-oversized-secret"
+	cfg.FakeSecretManager.PutSecret("sanity-project", secretID, make([]byte, 8*1024*1024))
+
+	req := mountRequest(t, cfg, "sanity-project", []string{secretID})
+	if _, err := client.Mount(context.Background(), req); err == nil {
+		t.Fatalf("Mount() succeeded for an oversized payload, want error")
+	}
+}
+
+// testCancelledContext asserts a Mount whose context times out mid-flight
+// returns with a Canceled or DeadlineExceeded error instead of hanging.
+func testCancelledContext(t *testing.T, client v1alpha1.CSIDriverProviderClient, cfg Config) {
+	secretID := "sanity-cancel-secret"
+	cfg.FakeSecretManager.PutSecret("sanity-project", secretID, []byte("value"))
+	cfg.FakeSecretManager.Delay("sanity-project", secretID, 5*time.Second)
+	defer cfg.FakeSecretManager.Delay("sanity-project", secretID, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	req := mountRequest(t, cfg, "sanity-project", []string{secretID})
+	_, err := client.Mount(ctx, req)
+	if status.Code(err) != codes.Canceled && status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("Mount() returned code %v, want Canceled or DeadlineExceeded", status.Code(err))
+	}
+}
+
+// mountRequest builds a MountRequest equivalent to what the driver sends
+// for a SecretProviderClass listing the given secrets.
+func mountRequest(t *testing.T, cfg Config, project string, secretIDs []string) *v1alpha1.MountRequest {
+	t.Helper()
+
+	var objects []map[string]string
+	for _, id := range secretIDs {
+		objects = append(objects, map[string]string{
+			"resourceName": fmt.Sprintf("projects/%s/secrets/%s/versions/latest", project, id),
+			"fileName":     id,
+		})
+	}
+	attributes, err := json.Marshal(map[string]interface{}{
+		"secrets": objects,
+	})
+	if err != nil {
+		t.Fatalf("marshalling attributes: %v", err)
+	}
+
+	return &v1alpha1.MountRequest{
+		Attributes: string(attributes),
+		TargetPath: cfg.TargetPath,
+		Permission: "420", // 0644
+	}
+}
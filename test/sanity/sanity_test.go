@@ -0,0 +1,96 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sanity
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/secrets-store-csi-driver-provider-gcp/test/internal/fakesecretmanager"
+)
+
+// providerPackage is the build target for the provider binary under test.
+const providerPackage = "github.com/GoogleCloudPlatform/secrets-store-csi-driver-provider-gcp"
+
+// fakeCredentials is a throwaway GOOGLE_APPLICATION_CREDENTIALS payload
+// accepted by the client libraries; its contents are never checked by the
+// fake Secret Manager server this harness stands up.
+const fakeCredentials = `{
+  "type": "authorized_user",
+  "client_id": "fake",
+  "client_secret": "fake",
+  "refresh_token": "fake"
+}`
+
+// TestProviderSanity builds the provider binary, launches it against a fake
+// Secret Manager backend, and drives the sanity suite over its gRPC socket.
+func TestProviderSanity(t *testing.T) {
+	tempDir := t.TempDir()
+
+	binPath := filepath.Join(tempDir, "provider")
+	build := exec.Command("go", "build", "-o", binPath, providerPackage)
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("building provider binary: %v\n%s", err, out)
+	}
+
+	fakeSM, err := fakesecretmanager.New()
+	if err != nil {
+		t.Fatalf("starting fake Secret Manager: %v", err)
+	}
+
+	credFile := filepath.Join(tempDir, "fake-credentials.json")
+	if err := os.WriteFile(credFile, []byte(fakeCredentials), 0644); err != nil {
+		t.Fatalf("writing fake credentials: %v", err)
+	}
+
+	socketPath := filepath.Join(tempDir, "provider.sock")
+	provider := exec.Command(binPath, "--endpoint", socketPath)
+	provider.Env = append(os.Environ(),
+		"GOOGLE_APPLICATION_CREDENTIALS="+credFile,
+		fmt.Sprintf("SECRETMANAGER_ENDPOINT=127.0.0.1:%d", fakeSM.Port()),
+	)
+	provider.Stdout = os.Stdout
+	provider.Stderr = os.Stderr
+	if err := provider.Start(); err != nil {
+		t.Fatalf("starting provider binary: %v", err)
+	}
+	defer provider.Process.Kill()
+
+	if err := waitForSocket(socketPath, 10*time.Second); err != nil {
+		t.Fatalf("waiting for provider socket: %v", err)
+	}
+
+	Test(t, Config{
+		SocketPath:        socketPath,
+		FakeSecretManager: fakeSM,
+		TargetPath:        filepath.Join(tempDir, "target"),
+	})
+}
+
+// waitForSocket polls for path to appear, to give the provider binary time
+// to start listening after Start returns.
+func waitForSocket(path string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("socket %s did not appear within %s", path, timeout)
+}